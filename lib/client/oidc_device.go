@@ -0,0 +1,230 @@
+/*
+Copyright 2015-2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gravitational/teleport/lib/auth"
+
+	"github.com/gravitational/trace"
+)
+
+// defaultDeviceCodePollInterval is used when the server omits "interval" in
+// its device_authorization response.
+const defaultDeviceCodePollInterval = 5 * time.Second
+
+// OIDCPasswordReq is passed by the web client to exchange a username,
+// password, and TOTP code for a Teleport cert via a connector's Resource
+// Owner Password Credentials ("direct") grant.
+type OIDCPasswordReq struct {
+	// User is a teleport username
+	User string `json:"user"`
+	// Password is user's password at the OIDC connector
+	Password string `json:"password"`
+	// OTPToken is second factor token
+	OTPToken string `json:"otp_token,omitempty"`
+	// PubKey is a public key user wishes to sign
+	PubKey []byte `json:"pub_key"`
+	// TTL is a desired TTL for the cert (max is still capped by server,
+	// however user can shorten the time)
+	TTL time.Duration `json:"ttl"`
+}
+
+// SSHAgentDirectGrantLogin exchanges a username, password, and TOTP code for
+// a Teleport cert using a connector's Resource Owner Password Credentials
+// grant, bypassing the browser redirect entirely. This is useful where a
+// full browser login is impossible, such as CI bots or Kiosk mode.
+func (c *CredentialsClient) SSHAgentDirectGrantLogin(ctx context.Context, user, password, totp string, pubKey []byte, ttl time.Duration) (*auth.SSHLoginResponse, error) {
+	re, err := c.clt.PostJSON(ctx, c.clt.Endpoint("webapi", "oidc", "password"), OIDCPasswordReq{
+		User:     user,
+		Password: password,
+		OTPToken: totp,
+		PubKey:   pubKey,
+		TTL:      ttl,
+	})
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	var out *auth.SSHLoginResponse
+	if err := json.Unmarshal(re.Bytes(), &out); err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	return out, nil
+}
+
+// DeviceAuthorizationResponse is the RFC 8628 device_authorization response
+// returned by webapi/oidc/device/authorize.
+type DeviceAuthorizationResponse struct {
+	// DeviceCode identifies the device authorization session to
+	// webapi/oidc/device/token.
+	DeviceCode string `json:"device_code"`
+	// UserCode is the short code the user types at VerificationURI.
+	UserCode string `json:"user_code"`
+	// VerificationURI is the URL the user visits to approve the request.
+	VerificationURI string `json:"verification_uri"`
+	// VerificationURIComplete optionally embeds UserCode in
+	// VerificationURI so the user doesn't have to type it in by hand.
+	VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
+	// ExpiresIn is the number of seconds DeviceCode remains valid for.
+	ExpiresIn int `json:"expires_in"`
+	// Interval is the minimum number of seconds the client must wait
+	// between polls of webapi/oidc/device/token.
+	Interval int `json:"interval,omitempty"`
+}
+
+// deviceTokenReq is passed by the web client to poll for the outcome of a
+// device authorization request.
+type deviceTokenReq struct {
+	// DeviceCode identifies the device authorization session.
+	DeviceCode string `json:"device_code"`
+	// PubKey is a public key user wishes to sign
+	PubKey []byte `json:"pub_key"`
+	// TTL is a desired TTL for the cert (max is still capped by server,
+	// however user can shorten the time)
+	TTL time.Duration `json:"ttl"`
+}
+
+// SSHAgentDeviceCodeLogin performs the RFC 8628 device authorization flow:
+// it obtains a device_code/user_code pair, prints the code and
+// verification URL for the user to approve on another device, then polls
+// for completion every "interval" seconds (5 seconds when the server omits
+// it) until "expires_in" runs out. Each "slow_down" error bumps the polling
+// period by 5 seconds; otherwise the period stays fixed, with no
+// exponential backoff.
+func (c *CredentialsClient) SSHAgentDeviceCodeLogin(ctx context.Context, pubKey []byte, ttl time.Duration) (*auth.SSHLoginResponse, error) {
+	authResp, err := c.clt.PostJSON(ctx, c.clt.Endpoint("webapi", "oidc", "device", "authorize"), struct {
+		PubKey []byte `json:"pub_key"`
+	}{PubKey: pubKey})
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	var device *DeviceAuthorizationResponse
+	if err := json.Unmarshal(authResp.Bytes(), &device); err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	if device.VerificationURIComplete != "" {
+		fmt.Printf("To authenticate, visit:\n\n  %v\n\nor visit %v and enter code: %v\n",
+			device.VerificationURIComplete, device.VerificationURI, device.UserCode)
+	} else {
+		fmt.Printf("To authenticate, visit %v and enter code: %v\n", device.VerificationURI, device.UserCode)
+	}
+
+	interval := time.Duration(device.Interval) * time.Second
+	if interval <= 0 {
+		interval = defaultDeviceCodePollInterval
+	}
+
+	var deadline time.Time
+	if device.ExpiresIn > 0 {
+		deadline = time.Now().Add(time.Duration(device.ExpiresIn) * time.Second)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, trace.Wrap(ctx.Err(), "cancelled by user")
+		case <-time.After(interval):
+		}
+
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return nil, trace.AccessDenied("device code expired before authorization was approved")
+		}
+
+		re, err := c.clt.PostJSON(ctx, c.clt.Endpoint("webapi", "oidc", "device", "token"), deviceTokenReq{
+			DeviceCode: device.DeviceCode,
+			PubKey:     pubKey,
+			TTL:        ttl,
+		})
+		if err != nil {
+			switch deviceCodeErrorCode(err) {
+			case "authorization_pending":
+				continue
+			case "slow_down":
+				interval += 5 * time.Second
+				continue
+			case "expired_token":
+				return nil, trace.AccessDenied("device code expired before authorization was approved")
+			case "access_denied":
+				return nil, trace.AccessDenied("device code authorization was denied")
+			default:
+				return nil, trace.Wrap(err)
+			}
+		}
+
+		var out *auth.SSHLoginResponse
+		if err := json.Unmarshal(re.Bytes(), &out); err != nil {
+			return nil, trace.Wrap(err)
+		}
+		return out, nil
+	}
+}
+
+// deviceCodeErrorBody is the RFC 8628 §3.5 error body returned by
+// webapi/oidc/device/token on a non-2xx poll response.
+type deviceCodeErrorBody struct {
+	Error string `json:"error"`
+}
+
+// deviceCodeKnownErrors matches one of the four RFC 8628 §3.5 device-flow
+// error codes sitting in an "error" JSON field, tolerating the field's
+// quotes being backslash-escaped -- as they are once the response body gets
+// folded into a wrapping error's message rather than kept as a standalone
+// JSON document. Matching on the "error" key rather than the bare word
+// keeps this from firing on an unrelated error that merely mentions
+// "slow_down" somewhere in prose.
+var deviceCodeKnownErrors = regexp.MustCompile(`\\*"error\\*"\s*:\s*\\*"(authorization_pending|slow_down|expired_token|access_denied)\\*"`)
+
+// deviceCodeErrorCode extracts the RFC 8628 error code (e.g.
+// "authorization_pending", "slow_down") from the JSON error body embedded
+// in err, if present. It first tries to decode the body as JSON starting
+// at its first "{", the common case where the transport embeds the raw
+// response bytes verbatim in the error message. If that fails -- for
+// example because the transport instead folds the body into a formatted,
+// backslash-escaped string -- it falls back to a tolerant regexp match over
+// the whole message. Errors that don't carry one of the four recognized
+// device-flow codes in an "error" field, rather than merely mentioning the
+// word somewhere in their text, are not classified here and fall through to
+// the caller's default handling.
+func deviceCodeErrorCode(err error) string {
+	msg := err.Error()
+
+	if start := strings.IndexByte(msg, '{'); start >= 0 {
+		var body deviceCodeErrorBody
+		if decodeErr := json.NewDecoder(strings.NewReader(msg[start:])).Decode(&body); decodeErr == nil {
+			switch body.Error {
+			case "authorization_pending", "slow_down", "expired_token", "access_denied":
+				return body.Error
+			}
+		}
+	}
+
+	if m := deviceCodeKnownErrors.FindStringSubmatch(msg); m != nil {
+		return m[1]
+	}
+
+	return ""
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2015-2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gravitational/trace"
+)
+
+func TestDeviceCodeErrorCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{
+			name: "raw JSON body embedded verbatim",
+			err:  fmt.Errorf(`device/token request failed: {"error":"authorization_pending"}`),
+			want: "authorization_pending",
+		},
+		{
+			name: "raw JSON body, slow_down",
+			err:  fmt.Errorf(`device/token request failed: {"error":"slow_down"}`),
+			want: "slow_down",
+		},
+		{
+			name: "body folded into a quoted, backslash-escaped message",
+			err:  fmt.Errorf("device/token request failed: %q", `{"error":"expired_token"}`),
+			want: "expired_token",
+		},
+		{
+			name: "trace-wrapped error whose message carries the backslash-escaped body",
+			err:  trace.Wrap(fmt.Errorf("%s", `failed to parse response: "{\"error\":\"access_denied\"}"`)),
+			want: "access_denied",
+		},
+		{
+			name: "unrelated error mentioning a code word in prose is not classified",
+			err:  fmt.Errorf("the request timed out, consider a slow_down in your polling"),
+			want: "",
+		},
+		{
+			name: "unrelated JSON body",
+			err:  fmt.Errorf(`device/token request failed: {"error":"server_error"}`),
+			want: "",
+		},
+		{
+			name: "no JSON at all",
+			err:  fmt.Errorf("connection reset by peer"),
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := deviceCodeErrorCode(tt.err); got != tt.want {
+				t.Errorf("deviceCodeErrorCode(%q) = %q, want %q", tt.err.Error(), got, tt.want)
+			}
+		})
+	}
+}
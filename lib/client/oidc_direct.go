@@ -0,0 +1,328 @@
+/*
+Copyright 2015-2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gravitational/trace"
+)
+
+// pkceVerifier holds the PKCE (RFC 7636) verifier/challenge pair for a
+// single Authorization Code flow.
+type pkceVerifier struct {
+	verifier  string
+	challenge string
+}
+
+// newPKCEVerifier generates a random code_verifier and its S256
+// code_challenge, as required by the OAuth2 PKCE extension.
+func newPKCEVerifier() (*pkceVerifier, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	verifier := base64.RawURLEncoding.EncodeToString(raw)
+
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return &pkceVerifier{verifier: verifier, challenge: challenge}, nil
+}
+
+// oidcTokens holds the tokens returned by the OIDC connector's token
+// endpoint once the Authorization Code has been exchanged.
+type oidcTokens struct {
+	IDToken      string `json:"id_token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// newOIDCCallbackListener opens a loopback listener to receive the OAuth2
+// callback. If bindAddr is empty, an ephemeral port on 127.0.0.1 is used.
+func newOIDCCallbackListener(bindAddr string) (net.Listener, string, error) {
+	if bindAddr == "" {
+		bindAddr = "127.0.0.1:0"
+	}
+
+	lsn, err := net.Listen("tcp", bindAddr)
+	if err != nil {
+		return nil, "", trace.Wrap(err, "unable to bind loopback listener for OIDC callback")
+	}
+
+	callbackURL := url.URL{
+		Scheme: "http",
+		Host:   lsn.Addr().String(),
+		Path:   "/callback",
+	}
+
+	return lsn, callbackURL.String(), nil
+}
+
+// newOIDCState generates a random value for the OAuth2 "state" parameter,
+// used to bind an Authorization Code callback to the request that started
+// it and so reject any code submitted by a party that didn't observe the
+// original redirect.
+func newOIDCState() (string, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", trace.Wrap(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// buildOIDCAuthURL builds the Authorization Code request URL sent to the
+// connector's issuer, including the PKCE code_challenge and the state used
+// to validate the callback.
+func buildOIDCAuthURL(login SSHLogin, pkce *pkceVerifier, callbackURL, state string) (string, error) {
+	if login.OIDCIssuerURL == "" {
+		return "", trace.BadParameter("missing OIDCIssuerURL")
+	}
+	if login.ClientID == "" {
+		return "", trace.BadParameter("missing ClientID")
+	}
+
+	scopes := login.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid"}
+	}
+
+	u, err := url.Parse(strings.TrimSuffix(login.OIDCIssuerURL, "/") + "/authorize")
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", login.ClientID)
+	q.Set("redirect_uri", callbackURL)
+	q.Set("scope", strings.Join(scopes, " "))
+	q.Set("code_challenge", pkce.challenge)
+	q.Set("code_challenge_method", "S256")
+	q.Set("state", state)
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+// waitForOIDCCallback blocks until the loopback listener receives the
+// OAuth2 callback request carrying wantState and returns its "code" query
+// parameter. Requests that don't carry a matching "state" -- a browser
+// prefetch, another tab, a stray local scanner -- are answered with 404
+// and otherwise ignored rather than failing the login, since they didn't
+// originate from the redirect we sent; only once state matches do we look
+// at "error"/"code" to decide whether the real login succeeded or failed.
+func waitForOIDCCallback(ctx context.Context, lsn net.Listener, wantState string) (string, error) {
+	codeC := make(chan string, 1)
+	errC := make(chan error, 1)
+
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("state") != wantState {
+				http.NotFound(w, r)
+				return
+			}
+
+			if errMsg := r.URL.Query().Get("error"); errMsg != "" {
+				fmt.Fprintln(w, "Login failed, you can close this window.")
+				flushResponse(w)
+				errC <- trace.AccessDenied("oidc authorization failed: %v", errMsg)
+				return
+			}
+
+			code := r.URL.Query().Get("code")
+			if code == "" {
+				fmt.Fprintln(w, "Login failed, you can close this window.")
+				flushResponse(w)
+				errC <- trace.BadParameter("missing code in OIDC callback")
+				return
+			}
+
+			fmt.Fprintln(w, "Login successful, you can close this window and return to tsh.")
+			flushResponse(w)
+			codeC <- code
+		}),
+	}
+	go srv.Serve(lsn)
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	select {
+	case code := <-codeC:
+		return code, nil
+	case err := <-errC:
+		return "", trace.Wrap(err)
+	case <-ctx.Done():
+		return "", trace.Wrap(ctx.Err(), "cancelled by user")
+	}
+}
+
+// flushResponse flushes w if its underlying ResponseWriter supports it, so
+// that the response is handed off to the browser before the caller tears
+// down the server that's about to send it.
+func flushResponse(w http.ResponseWriter) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// oidcTokenHTTPClient returns the *http.Client used to reach the connector's
+// token endpoint, routed through login.ProxyURL (or the environment proxy)
+// exactly like CredentialsClient's own webapi calls, and applying
+// login.TLSProfile (TLSProfileDefault when empty) and login.ProxyPins to the
+// handshake.
+func oidcTokenHTTPClient(login SSHLogin) (*http.Client, error) {
+	clt := newProxyAwareWebClient(nil, login.ProxyURL)
+
+	transport, ok := clt.Transport.(*http.Transport)
+	if !ok || transport == nil {
+		transport = &http.Transport{}
+		clt.Transport = transport
+	}
+
+	profile := login.TLSProfile
+	if profile == "" {
+		profile = TLSProfileDefault
+	}
+
+	tlsConfig, err := tlsConfigForProfile(profile, login.Pool)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if len(login.ProxyPins) > 0 {
+		tlsConfig.VerifyPeerCertificate = verifyPeerCertificateSPKIPins(login.ProxyPins)
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	return clt, nil
+}
+
+// exchangeOIDCCode exchanges the Authorization Code for tokens at the
+// connector's token endpoint, presenting the PKCE code_verifier in place of
+// a client secret.
+func exchangeOIDCCode(ctx context.Context, login SSHLogin, pkce *pkceVerifier, callbackURL string, code string) (*oidcTokens, error) {
+	tokenURL := strings.TrimSuffix(login.OIDCIssuerURL, "/") + "/token"
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("client_id", login.ClientID)
+	form.Set("code", code)
+	form.Set("redirect_uri", callbackURL)
+	form.Set("code_verifier", pkce.verifier)
+
+	req, err := http.NewRequest(http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	httpClient, err := oidcTokenHTTPClient(login)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, trace.AccessDenied("oidc token exchange failed: %v", string(body))
+	}
+
+	var tokens oidcTokens
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	return &tokens, nil
+}
+
+// oidcRefreshTokenPath returns the path under ~/.tsh that the OIDC refresh
+// token for proxyAddr is persisted to, scoped per proxy so that logging
+// into one cluster doesn't clobber another's refresh token.
+func oidcRefreshTokenPath(proxyAddr string) (string, error) {
+	if proxyAddr == "" {
+		return "", trace.BadParameter("missing proxy address")
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	return filepath.Join(home, ".tsh", "keys", strings.ReplaceAll(proxyAddr, ":", "_"), "oidc_refresh_token"), nil
+}
+
+// persistOIDCRefreshToken writes the refresh token to ~/.tsh, keyed by
+// proxyAddr, so that future tsh invocations against that proxy can silently
+// refresh certs without a browser.
+func persistOIDCRefreshToken(proxyAddr, refreshToken string) error {
+	path, err := oidcRefreshTokenPath(proxyAddr)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return trace.Wrap(err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(refreshToken), 0600); err != nil {
+		return trace.Wrap(err)
+	}
+
+	return nil
+}
+
+// LoadOIDCRefreshToken reads a previously persisted OIDC refresh token for
+// proxyAddr from ~/.tsh, if one exists.
+func LoadOIDCRefreshToken(proxyAddr string) (string, error) {
+	path, err := oidcRefreshTokenPath(proxyAddr)
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+
+	token, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", trace.Wrap(err)
+	}
+
+	return strings.TrimSpace(string(token)), nil
+}
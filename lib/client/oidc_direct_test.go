@@ -0,0 +1,163 @@
+/*
+Copyright 2015-2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gravitational/trace"
+)
+
+func TestWaitForOIDCCallbackHappyPath(t *testing.T) {
+	lsn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	codeC := make(chan string, 1)
+	errC := make(chan error, 1)
+	go func() {
+		code, err := waitForOIDCCallback(ctx, lsn, "the-state")
+		if err != nil {
+			errC <- err
+			return
+		}
+		codeC <- code
+	}()
+
+	resp, err := http.Get(fmt.Sprintf("http://%v/callback?state=the-state&code=the-code", lsn.Addr()))
+	if err != nil {
+		t.Fatalf("GET callback: %v", err)
+	}
+	resp.Body.Close()
+
+	select {
+	case code := <-codeC:
+		if code != "the-code" {
+			t.Errorf("waitForOIDCCallback() = %q, want %q", code, "the-code")
+		}
+	case err := <-errC:
+		t.Fatalf("waitForOIDCCallback() returned error: %v", err)
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for waitForOIDCCallback to return")
+	}
+}
+
+func TestWaitForOIDCCallbackIgnoresMismatchedState(t *testing.T) {
+	lsn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	codeC := make(chan string, 1)
+	errC := make(chan error, 1)
+	go func() {
+		code, err := waitForOIDCCallback(ctx, lsn, "the-state")
+		if err != nil {
+			errC <- err
+			return
+		}
+		codeC <- code
+	}()
+
+	// A stray hit with the wrong state -- a browser prefetch, another
+	// tab, a local scanner -- should be 404ed and not fail the login.
+	strayResp, err := http.Get(fmt.Sprintf("http://%v/callback?state=wrong-state&code=stolen-code", lsn.Addr()))
+	if err != nil {
+		t.Fatalf("GET callback with mismatched state: %v", err)
+	}
+	strayResp.Body.Close()
+	if strayResp.StatusCode != http.StatusNotFound {
+		t.Errorf("mismatched-state callback status = %v, want %v", strayResp.StatusCode, http.StatusNotFound)
+	}
+
+	select {
+	case <-codeC:
+		t.Fatal("waitForOIDCCallback returned after a mismatched-state request")
+	case err := <-errC:
+		t.Fatalf("waitForOIDCCallback errored after a mismatched-state request: %v", err)
+	case <-time.After(100 * time.Millisecond):
+		// Still listening, as expected.
+	}
+
+	// The real redirect, with the correct state, should still succeed.
+	resp, err := http.Get(fmt.Sprintf("http://%v/callback?state=the-state&code=the-code", lsn.Addr()))
+	if err != nil {
+		t.Fatalf("GET callback: %v", err)
+	}
+	resp.Body.Close()
+
+	select {
+	case code := <-codeC:
+		if code != "the-code" {
+			t.Errorf("waitForOIDCCallback() = %q, want %q", code, "the-code")
+		}
+	case err := <-errC:
+		t.Fatalf("waitForOIDCCallback() returned error: %v", err)
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for waitForOIDCCallback to return")
+	}
+}
+
+func TestWaitForOIDCCallbackErrorParam(t *testing.T) {
+	lsn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	codeC := make(chan string, 1)
+	errC := make(chan error, 1)
+	go func() {
+		code, err := waitForOIDCCallback(ctx, lsn, "the-state")
+		if err != nil {
+			errC <- err
+			return
+		}
+		codeC <- code
+	}()
+
+	resp, err := http.Get(fmt.Sprintf("http://%v/callback?state=the-state&error=access_denied", lsn.Addr()))
+	if err != nil {
+		t.Fatalf("GET callback: %v", err)
+	}
+	resp.Body.Close()
+
+	select {
+	case code := <-codeC:
+		t.Fatalf("waitForOIDCCallback() = %q, want an error", code)
+	case err := <-errC:
+		if !trace.IsAccessDenied(err) {
+			t.Errorf("waitForOIDCCallback() error = %v, want AccessDenied", err)
+		}
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for waitForOIDCCallback to return")
+	}
+}
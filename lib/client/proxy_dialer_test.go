@@ -0,0 +1,187 @@
+/*
+Copyright 2015-2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gravitational/trace"
+)
+
+// fakeConnectProxy starts a listener that accepts a single connection,
+// reads one CONNECT request from it and hands it to handle, which is responsible for writing
+// the proxy's response (and any further bytes it wants to test framing
+// around). It returns the proxy's "host:port" for use in a proxy URL.
+func fakeConnectProxy(t *testing.T, handle func(conn net.Conn, connectReq *http.Request)) string {
+	t.Helper()
+
+	lsn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	t.Cleanup(func() { lsn.Close() })
+
+	go func() {
+		conn, err := lsn.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			return
+		}
+		handle(conn, req)
+	}()
+
+	return lsn.Addr().String()
+}
+
+func TestDialViaHTTPConnectSuccess(t *testing.T) {
+	const target = "backend.example.com:443"
+
+	proxyAddr := fakeConnectProxy(t, func(conn net.Conn, req *http.Request) {
+		if req.Method != http.MethodConnect {
+			t.Errorf("method = %v, want CONNECT", req.Method)
+		}
+		if req.Host != target {
+			t.Errorf("CONNECT host = %q, want %q", req.Host, target)
+		}
+		fmt.Fprint(conn, "HTTP/1.1 200 Connection Established\r\n\r\n")
+
+		// Echo back whatever the tunnel sends, to prove the caller gets
+		// the raw connection once the tunnel is up.
+		buf := make([]byte, 5)
+		io.ReadFull(conn, buf)
+		conn.Write(buf)
+	})
+
+	proxyURL, err := url.Parse("http://" + proxyAddr)
+	if err != nil {
+		t.Fatalf("url.Parse: %v", err)
+	}
+
+	conn, err := dialViaHTTPConnect(context.Background(), proxyURL, target)
+	if err != nil {
+		t.Fatalf("dialViaHTTPConnect: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("ReadFull: %v", err)
+	}
+	if string(buf) != "hello" {
+		t.Errorf("echoed data = %q, want %q", buf, "hello")
+	}
+}
+
+func TestDialViaHTTPConnectProxyAuthRequired(t *testing.T) {
+	proxyAddr := fakeConnectProxy(t, func(conn net.Conn, req *http.Request) {
+		fmt.Fprint(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+	})
+
+	proxyURL, err := url.Parse("http://" + proxyAddr)
+	if err != nil {
+		t.Fatalf("url.Parse: %v", err)
+	}
+
+	_, err = dialViaHTTPConnect(context.Background(), proxyURL, "backend.example.com:443")
+	if err == nil {
+		t.Fatal("dialViaHTTPConnect: expected error, got nil")
+	}
+	if !trace.IsAccessDenied(err) {
+		t.Errorf("dialViaHTTPConnect error = %v, want AccessDenied", err)
+	}
+}
+
+func TestDialViaHTTPConnectNonOKStatus(t *testing.T) {
+	proxyAddr := fakeConnectProxy(t, func(conn net.Conn, req *http.Request) {
+		fmt.Fprint(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+	})
+
+	proxyURL, err := url.Parse("http://" + proxyAddr)
+	if err != nil {
+		t.Fatalf("url.Parse: %v", err)
+	}
+
+	_, err = dialViaHTTPConnect(context.Background(), proxyURL, "backend.example.com:443")
+	if err == nil {
+		t.Fatal("dialViaHTTPConnect: expected error, got nil")
+	}
+	if !trace.IsConnectionProblem(err) {
+		t.Errorf("dialViaHTTPConnect error = %v, want ConnectionProblem", err)
+	}
+}
+
+func TestDialViaHTTPConnectRejectsDataAheadOfHandshake(t *testing.T) {
+	proxyAddr := fakeConnectProxy(t, func(conn net.Conn, req *http.Request) {
+		// A misbehaving (or MITM-ing) proxy that pushes bytes before the
+		// "tunnel established" response has finished being read must not
+		// have those bytes silently handed to the TLS client as if they
+		// came from the real backend.
+		fmt.Fprint(conn, "HTTP/1.1 200 Connection Established\r\n\r\nextra-bytes-not-tls")
+	})
+
+	proxyURL, err := url.Parse("http://" + proxyAddr)
+	if err != nil {
+		t.Fatalf("url.Parse: %v", err)
+	}
+
+	_, err = dialViaHTTPConnect(context.Background(), proxyURL, "backend.example.com:443")
+	if err == nil {
+		t.Fatal("dialViaHTTPConnect: expected error, got nil")
+	}
+	if !trace.IsBadParameter(err) {
+		t.Errorf("dialViaHTTPConnect error = %v, want BadParameter", err)
+	}
+}
+
+func TestDialViaHTTPConnectSendsProxyAuth(t *testing.T) {
+	proxyAddr := fakeConnectProxy(t, func(conn net.Conn, req *http.Request) {
+		user, pass, ok := req.BasicAuth()
+		if !ok || user != "alice" || pass != "s3cret" {
+			t.Errorf("BasicAuth() = (%q, %q, %v), want (\"alice\", \"s3cret\", true)", user, pass, ok)
+		}
+		fmt.Fprint(conn, "HTTP/1.1 200 Connection Established\r\n\r\n")
+	})
+
+	proxyURL, err := url.Parse("http://alice:s3cret@" + proxyAddr)
+	if err != nil {
+		t.Fatalf("url.Parse: %v", err)
+	}
+
+	conn, err := dialViaHTTPConnect(context.Background(), proxyURL, "backend.example.com:443")
+	if err != nil {
+		t.Fatalf("dialViaHTTPConnect: %v", err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2015-2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"github.com/gravitational/trace"
+)
+
+// TLSProfile selects the TLS version/cipher suite tier used when talking to
+// a server.
+type TLSProfile string
+
+const (
+	// TLSProfileSecure restricts the handshake to TLS 1.3 only, AEAD
+	// suites, and X25519/P-256 curves. This is the default tier used to
+	// talk to the Teleport auth/proxy API.
+	TLSProfileSecure TLSProfile = "secure"
+	// TLSProfileDefault allows TLS 1.2+ with a curated list of modern
+	// ECDHE-AEAD suites. This is the default tier for the OIDC connector's
+	// token endpoint during SSHAgentOIDCLogin, since IdPs' TLS stacks are
+	// outside our control.
+	TLSProfileDefault TLSProfile = "default"
+	// TLSProfileLegacy allows TLS 1.2+ with broader CBC suites, for older
+	// proxies that don't yet support modern AEAD suites.
+	TLSProfileLegacy TLSProfile = "legacy"
+)
+
+// modernCipherSuites is the curated list of ECDHE-AEAD suites used by the
+// Default profile. The Secure profile does not use it: it only allows TLS
+// 1.3, whose suites are not configurable.
+var modernCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+}
+
+// legacyCipherSuites extends modernCipherSuites with broader CBC suites for
+// proxies that predate AEAD support.
+var legacyCipherSuites = append(append([]uint16{}, modernCipherSuites...),
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+)
+
+// tlsConfigForProfile builds the *tls.Config matching profile. An empty
+// profile defaults to TLSProfileSecure, rejecting any downgrade.
+func tlsConfigForProfile(profile TLSProfile, pool *x509.CertPool) (*tls.Config, error) {
+	cfg := &tls.Config{RootCAs: pool}
+
+	switch profile {
+	case "", TLSProfileSecure:
+		cfg.MinVersion = tls.VersionTLS13
+		cfg.CurvePreferences = []tls.CurveID{tls.X25519, tls.CurveP256}
+	case TLSProfileDefault:
+		cfg.MinVersion = tls.VersionTLS12
+		cfg.CipherSuites = modernCipherSuites
+		cfg.CurvePreferences = []tls.CurveID{tls.X25519, tls.CurveP256}
+	case TLSProfileLegacy:
+		cfg.MinVersion = tls.VersionTLS12
+		cfg.CipherSuites = legacyCipherSuites
+	default:
+		return nil, trace.BadParameter("unknown TLS profile %q", profile)
+	}
+
+	return cfg, nil
+}
+
+// buildWebHTTPClient builds the *http.Client used to talk to the proxy,
+// applying cfg's proxy, TLS profile, and SPKI pinning settings. An empty
+// cfg.TLSProfile defaults to TLSProfileSecure whenever cfg.Insecure is
+// false, so this never returns nil for the common, non-insecure case.
+func buildWebHTTPClient(cfg CredentialsClientConfig, pool *x509.CertPool) (*http.Client, error) {
+	if !cfg.Insecure && cfg.TLSProfile == "" {
+		cfg.TLSProfile = TLSProfileSecure
+	}
+
+	var base *http.Client
+	switch {
+	case cfg.Insecure:
+		base = NewInsecureWebClient()
+	case pool != nil:
+		base = newClientWithPool(pool)
+	default:
+		base = &http.Client{}
+	}
+
+	clt := newProxyAwareWebClient(base, cfg.HTTPProxy)
+
+	if cfg.Insecure {
+		return clt, nil
+	}
+
+	transport, ok := clt.Transport.(*http.Transport)
+	if !ok || transport == nil {
+		transport = &http.Transport{}
+		clt.Transport = transport
+	}
+
+	tlsConfig, err := tlsConfigForProfile(cfg.TLSProfile, pool)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	if len(cfg.ProxyPins) > 0 {
+		// We still want full chain/hostname verification, on top of the
+		// pin check, so keep Go's default verification and layer the pin
+		// check in VerifyPeerCertificate, which runs after it succeeds.
+		tlsConfig.VerifyPeerCertificate = verifyPeerCertificateSPKIPins(cfg.ProxyPins)
+	}
+
+	transport.TLSClientConfig = tlsConfig
+
+	return clt, nil
+}
+
+// verifyPeerCertificateSPKIPins returns a tls.Config.VerifyPeerCertificate
+// callback that fails the handshake unless one of the presented
+// certificates' SPKI hashes matches a pin of the form "sha256//<base64>".
+func verifyPeerCertificateSPKIPins(pins []string) func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		for _, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				continue
+			}
+			sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			digest := "sha256//" + base64.StdEncoding.EncodeToString(sum[:])
+			for _, pin := range pins {
+				if strings.EqualFold(pin, digest) {
+					return nil
+				}
+			}
+		}
+		return trace.AccessDenied("certificate presented by proxy does not match any --proxy-pin")
+	}
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2015-2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTLSConfigForProfile(t *testing.T) {
+	tests := []struct {
+		profile     TLSProfile
+		wantMinVers uint16
+	}{
+		{profile: "", wantMinVers: tls.VersionTLS13},
+		{profile: TLSProfileSecure, wantMinVers: tls.VersionTLS13},
+		{profile: TLSProfileDefault, wantMinVers: tls.VersionTLS12},
+		{profile: TLSProfileLegacy, wantMinVers: tls.VersionTLS12},
+	}
+
+	for _, tt := range tests {
+		cfg, err := tlsConfigForProfile(tt.profile, nil)
+		if err != nil {
+			t.Errorf("tlsConfigForProfile(%q): unexpected error: %v", tt.profile, err)
+			continue
+		}
+		if cfg.MinVersion != tt.wantMinVers {
+			t.Errorf("tlsConfigForProfile(%q): MinVersion = %v, want %v", tt.profile, cfg.MinVersion, tt.wantMinVers)
+		}
+	}
+
+	if _, err := tlsConfigForProfile("bogus", nil); err == nil {
+		t.Error("tlsConfigForProfile(\"bogus\", nil): expected error, got nil")
+	}
+}
+
+func TestBuildWebHTTPClientSPKIPinning(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+
+	sum := sha256.Sum256(srv.Certificate().RawSubjectPublicKeyInfo)
+	validPin := "sha256//" + base64.StdEncoding.EncodeToString(sum[:])
+
+	clt, err := buildWebHTTPClient(CredentialsClientConfig{
+		TLSProfile: TLSProfileDefault,
+		ProxyPins:  []string{validPin},
+	}, pool)
+	if err != nil {
+		t.Fatalf("buildWebHTTPClient: %v", err)
+	}
+
+	resp, err := clt.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Get with matching pin: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("got status %v, want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	wrongSum := sha256.Sum256([]byte("not the server's key"))
+	wrongPin := "sha256//" + base64.StdEncoding.EncodeToString(wrongSum[:])
+
+	clt, err = buildWebHTTPClient(CredentialsClientConfig{
+		TLSProfile: TLSProfileDefault,
+		ProxyPins:  []string{wrongPin},
+	}, pool)
+	if err != nil {
+		t.Fatalf("buildWebHTTPClient: %v", err)
+	}
+
+	if _, err := clt.Get(srv.URL); err == nil {
+		t.Error("Get with mismatched pin: expected error, got nil")
+	}
+}
+
+func TestBuildWebHTTPClientNegotiatesProfile(t *testing.T) {
+	tests := []struct {
+		profile TLSProfile
+		// serverMaxVersion caps the server's TLS version, so that the
+		// TLS 1.2+ profiles negotiate a TLS 1.2 suite that can be checked.
+		serverMaxVersion uint16
+		wantVersion      uint16
+	}{
+		{profile: TLSProfileSecure, wantVersion: tls.VersionTLS13},
+		{profile: TLSProfileDefault, serverMaxVersion: tls.VersionTLS12, wantVersion: tls.VersionTLS12},
+		{profile: TLSProfileLegacy, serverMaxVersion: tls.VersionTLS12, wantVersion: tls.VersionTLS12},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.profile), func(t *testing.T) {
+			srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+			if tt.serverMaxVersion != 0 {
+				srv.TLS = &tls.Config{MaxVersion: tt.serverMaxVersion}
+			}
+			srv.StartTLS()
+			defer srv.Close()
+
+			pool := x509.NewCertPool()
+			pool.AddCert(srv.Certificate())
+
+			clt, err := buildWebHTTPClient(CredentialsClientConfig{TLSProfile: tt.profile}, pool)
+			if err != nil {
+				t.Fatalf("buildWebHTTPClient: %v", err)
+			}
+
+			resp, err := clt.Get(srv.URL)
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.TLS == nil {
+				t.Fatal("response carries no TLS connection state")
+			}
+			if resp.TLS.Version != tt.wantVersion {
+				t.Errorf("negotiated version = %#x, want %#x", resp.TLS.Version, tt.wantVersion)
+			}
+			if tt.profile != TLSProfileSecure {
+				matched := false
+				for _, cs := range modernCipherSuites {
+					if cs == resp.TLS.CipherSuite {
+						matched = true
+						break
+					}
+				}
+				if !matched {
+					t.Errorf("negotiated suite %#x is not one of modernCipherSuites", resp.TLS.CipherSuite)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildWebHTTPClientSecureRejectsTLS12Downgrade(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	srv.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	srv.StartTLS()
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+
+	clt, err := buildWebHTTPClient(CredentialsClientConfig{TLSProfile: TLSProfileSecure}, pool)
+	if err != nil {
+		t.Fatalf("buildWebHTTPClient: %v", err)
+	}
+
+	if _, err := clt.Get(srv.URL); err == nil {
+		t.Error("TLSProfileSecure talking to a TLS-1.2-only server: expected handshake failure, got nil")
+	}
+}
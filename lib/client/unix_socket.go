@@ -0,0 +1,91 @@
+/*
+Copyright 2015-2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/gravitational/teleport"
+
+	"github.com/gravitational/roundtrip"
+	"github.com/gravitational/trace"
+
+	"github.com/sirupsen/logrus"
+)
+
+// newUnixSocketCredentialsClient creates a CredentialsClient that dials
+// cfg.UnixSocket for every request instead of opening a TCP connection to
+// cfg.ProxyAddr. ProxyAddr is only used to build the synthetic Host header
+// so the proxy's virtual-host routing keeps working; no DNS lookup or TCP
+// dial against it ever happens.
+func newUnixSocketCredentialsClient(cfg CredentialsClientConfig) (*CredentialsClient, error) {
+	log := logrus.WithFields(logrus.Fields{
+		trace.Component: teleport.ComponentClient,
+	})
+	log.Debugf("HTTPS client init(unixSocket=%v, proxyAddr=%v)", cfg.UnixSocket, cfg.ProxyAddr)
+
+	host, port, err := net.SplitHostPort(cfg.ProxyAddr)
+	if err != nil || host == "" || port == "" {
+		if err != nil {
+			log.Error(err)
+		}
+		return nil, trace.BadParameter("'%v' is not a valid proxy address", cfg.ProxyAddr)
+	}
+
+	scheme := cfg.UpstreamScheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	if scheme != "http" && scheme != HTTPS {
+		return nil, trace.BadParameter("unsupported UpstreamScheme %q, must be \"http\" or %q", scheme, HTTPS)
+	}
+
+	baseURL := scheme + "://" + net.JoinHostPort(host, port)
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, trace.BadParameter("'%v' is not a valid proxy address", baseURL)
+	}
+
+	socketPath := cfg.UnixSocket
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+		},
+	}
+	if scheme == HTTPS {
+		// The socket's file permissions are the transport security
+		// boundary here, not the TLS handshake, so skip verification of a
+		// certificate that was never meant to be checked against a
+		// unix:// address.
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	clt, err := NewWebClient(baseURL, roundtrip.HTTPClient(&http.Client{Transport: transport}))
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	return &CredentialsClient{
+		log: log,
+		clt: clt,
+		url: u,
+	}, nil
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2015-2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewUnixSocketCredentialsClient(t *testing.T) {
+	sockPath := filepath.Join(t.TempDir(), "proxy.sock")
+
+	lsn, err := net.Listen("unix", sockPath)
+	if err != nil {
+		t.Fatalf("listen on unix socket: %v", err)
+	}
+	defer lsn.Close()
+
+	const proxyAddr = "proxy.example.com:3080"
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/webapi/ping", func(w http.ResponseWriter, r *http.Request) {
+		if r.Host != proxyAddr {
+			t.Errorf("Host header = %q, want %q", r.Host, proxyAddr)
+		}
+		json.NewEncoder(w).Encode(PingResponse{ServerVersion: "test-version"})
+	})
+	go http.Serve(lsn, mux)
+
+	clt, err := NewCredentialsClientWithConfig(CredentialsClientConfig{
+		ProxyAddr:  proxyAddr,
+		UnixSocket: sockPath,
+	})
+	if err != nil {
+		t.Fatalf("NewCredentialsClientWithConfig: %v", err)
+	}
+
+	resp, err := clt.Ping(context.Background(), "")
+	if err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+	if resp.ServerVersion != "test-version" {
+		t.Errorf("ServerVersion = %q, want %q", resp.ServerVersion, "test-version")
+	}
+}
+
+func TestNewUnixSocketCredentialsClientInvalidScheme(t *testing.T) {
+	_, err := NewCredentialsClientWithConfig(CredentialsClientConfig{
+		ProxyAddr:      "proxy.example.com:3080",
+		UnixSocket:     filepath.Join(t.TempDir(), "proxy.sock"),
+		UpstreamScheme: "ftp",
+	})
+	if err == nil {
+		t.Error("expected error for unsupported UpstreamScheme, got nil")
+	}
+}
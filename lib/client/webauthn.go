@@ -0,0 +1,146 @@
+/*
+Copyright 2015-2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+
+	"github.com/gravitational/trace"
+	"github.com/keys-pub/go-libfido2"
+	"github.com/tstranex/u2f"
+)
+
+// CredentialAssertion is the WebAuthn PublicKeyCredentialRequestOptions sent
+// by the proxy describing the challenge to sign.
+type CredentialAssertion struct {
+	// Challenge is the base64url-encoded WebAuthn challenge.
+	Challenge string `json:"challenge"`
+	// RPID is the Relying Party ID the assertion is scoped to.
+	RPID string `json:"rpId"`
+	// AllowCredentials lists the credential IDs the server will accept. Empty
+	// for passwordless/resident-key assertions.
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials,omitempty"`
+	// UserVerification is the requested user verification policy.
+	UserVerification string `json:"userVerification,omitempty"`
+}
+
+// CredentialDescriptor identifies a single allowed credential.
+type CredentialDescriptor struct {
+	// ID is the base64url-encoded credential ID.
+	ID string `json:"id"`
+	// Type is always "public-key".
+	Type string `json:"type"`
+}
+
+// AuthenticatorAssertionResponse is the signed assertion returned by the
+// authenticator, mirroring the browser's AuthenticatorAssertionResponse.
+type AuthenticatorAssertionResponse struct {
+	// CredentialID is the base64url-encoded ID of the credential used to
+	// sign the assertion.
+	CredentialID string `json:"id"`
+	// ClientDataJSON is the base64url-encoded CollectedClientData.
+	ClientDataJSON string `json:"clientDataJSON"`
+	// AuthenticatorData is the base64url-encoded authenticator data.
+	AuthenticatorData string `json:"authenticatorData"`
+	// Signature is the base64url-encoded assertion signature.
+	Signature string `json:"signature"`
+	// UserHandle is the base64url-encoded user handle, set for
+	// resident-key/passwordless assertions.
+	UserHandle string `json:"userHandle,omitempty"`
+}
+
+// signU2FChallenge signs a legacy U2F sign request using an in-process
+// CTAP2 authenticator, replacing the previous u2f-host subprocess. This
+// removes the external binary dependency and the fixed-size stdout/stderr
+// buffers that limited it to short responses.
+func signU2FChallenge(ctx context.Context, challenge *u2f.SignRequest) (*u2f.SignResponse, error) {
+	device, err := findFIDO2Device(ctx)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	defer device.Close()
+
+	resp, err := device.SignU2F(challenge)
+	if err != nil {
+		return nil, trace.Wrap(err, "security key declined to sign the U2F challenge")
+	}
+
+	return resp, nil
+}
+
+// signWebAuthnAssertion signs a WebAuthn assertion challenge using an
+// in-process CTAP2 authenticator, supporting modern FIDO2 security keys
+// including resident-key/UV (passwordless) credentials.
+func signWebAuthnAssertion(ctx context.Context, assertion *CredentialAssertion) (*AuthenticatorAssertionResponse, error) {
+	device, err := findFIDO2Device(ctx)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	defer device.Close()
+
+	resp, err := device.SignWebAuthn(assertion)
+	if err != nil {
+		return nil, trace.Wrap(err, "security key declined to sign the WebAuthn assertion")
+	}
+
+	return resp, nil
+}
+
+// fido2Device is implemented by the CTAP2/HID authenticator used to sign
+// both legacy U2F and modern WebAuthn challenges.
+type fido2Device interface {
+	SignU2F(challenge *u2f.SignRequest) (*u2f.SignResponse, error)
+	SignWebAuthn(assertion *CredentialAssertion) (*AuthenticatorAssertionResponse, error)
+	Close()
+}
+
+// findFIDO2Device locates the first attached FIDO2/CTAP2 HID authenticator.
+// Callers must Close the returned device once done.
+func findFIDO2Device(ctx context.Context) (fido2Device, error) {
+	locs, err := libfido2.DeviceLocations()
+	if err != nil {
+		return nil, trace.Wrap(err, "unable to enumerate FIDO2 devices")
+	}
+	if len(locs) == 0 {
+		return nil, trace.NotFound("no FIDO2 security key found, please insert one")
+	}
+
+	dev, err := libfido2.NewDevice(locs[0].Path)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	return &hidFIDO2Device{ctx: ctx, dev: dev}, nil
+}
+
+// hidFIDO2Device adapts a go-libfido2 device to the fido2Device interface.
+type hidFIDO2Device struct {
+	ctx context.Context
+	dev *libfido2.Device
+}
+
+func (d *hidFIDO2Device) SignU2F(challenge *u2f.SignRequest) (*u2f.SignResponse, error) {
+	return signU2FWithDevice(d.ctx, d.dev, challenge)
+}
+
+func (d *hidFIDO2Device) SignWebAuthn(assertion *CredentialAssertion) (*AuthenticatorAssertionResponse, error) {
+	return signWebAuthnWithDevice(d.ctx, d.dev, assertion)
+}
+
+func (d *hidFIDO2Device) Close() {
+	d.dev.Close()
+}
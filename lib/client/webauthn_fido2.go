@@ -0,0 +1,123 @@
+/*
+Copyright 2015-2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/gravitational/trace"
+	"github.com/keys-pub/go-libfido2"
+	"github.com/tstranex/u2f"
+)
+
+// clientData mirrors the browser's CollectedClientData that is hashed and
+// signed as part of both the legacy U2F and WebAuthn assertions.
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// signU2FWithDevice signs a legacy U2F sign request by building the
+// equivalent CTAP2 assertion and translating the result back into the
+// u2f.SignResponse wire format the proxy expects.
+func signU2FWithDevice(ctx context.Context, dev *libfido2.Device, challenge *u2f.SignRequest) (*u2f.SignResponse, error) {
+	cd := clientData{
+		Type:      "navigator.id.getAssertion",
+		Challenge: challenge.Challenge,
+		Origin:    challenge.AppID,
+	}
+	cdJSON, err := json.Marshal(cd)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	cdHash := sha256.Sum256(cdJSON)
+
+	assertion, err := dev.Assertion(
+		challenge.AppID,
+		cdHash[:],
+		[][]byte{decodeKeyHandleOrRaw(challenge.KeyHandle)},
+		"",
+		&libfido2.AssertionOpts{UP: libfido2.True},
+	)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	return &u2f.SignResponse{
+		KeyHandle:     challenge.KeyHandle,
+		SignatureData: base64.RawURLEncoding.EncodeToString(assertion.Sig),
+		ClientData:    base64.RawURLEncoding.EncodeToString(cdJSON),
+	}, nil
+}
+
+// signWebAuthnWithDevice signs a WebAuthn assertion challenge, optionally
+// with no AllowCredentials (a resident-key/UV, i.e. passwordless, request).
+func signWebAuthnWithDevice(ctx context.Context, dev *libfido2.Device, assertion *CredentialAssertion) (*AuthenticatorAssertionResponse, error) {
+	cd := clientData{
+		Type:      "webauthn.get",
+		Challenge: assertion.Challenge,
+		Origin:    "https://" + assertion.RPID,
+	}
+	cdJSON, err := json.Marshal(cd)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	cdHash := sha256.Sum256(cdJSON)
+
+	var credIDs [][]byte
+	for _, c := range assertion.AllowCredentials {
+		credIDs = append(credIDs, decodeKeyHandleOrRaw(c.ID))
+	}
+
+	opts := &libfido2.AssertionOpts{UP: libfido2.True}
+	if assertion.UserVerification == "required" {
+		opts.UV = libfido2.True
+	}
+
+	resp, err := dev.Assertion(assertion.RPID, cdHash[:], credIDs, "", opts)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	credID := resp.CredentialID
+	if len(credIDs) == 1 {
+		credID = credIDs[0]
+	}
+
+	return &AuthenticatorAssertionResponse{
+		CredentialID:      base64.RawURLEncoding.EncodeToString(credID),
+		ClientDataJSON:    base64.RawURLEncoding.EncodeToString(cdJSON),
+		AuthenticatorData: base64.RawURLEncoding.EncodeToString(resp.AuthDataCBOR),
+		Signature:         base64.RawURLEncoding.EncodeToString(resp.Sig),
+		UserHandle:        base64.RawURLEncoding.EncodeToString(resp.User.ID),
+	}, nil
+}
+
+// decodeKeyHandleOrRaw decodes a base64url-encoded key handle/credential ID.
+// Malformed handles are passed through to the authenticator as-is so it can
+// reject them, rather than failing the whole request client-side.
+func decodeKeyHandleOrRaw(h string) []byte {
+	decoded, err := base64.RawURLEncoding.DecodeString(h)
+	if err != nil {
+		return []byte(h)
+	}
+	return decoded
+}
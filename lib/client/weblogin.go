@@ -21,12 +21,11 @@ import (
 	"crypto/x509"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net"
 	"net/url"
 	"os/exec"
 	"runtime"
-	"strings"
+	"sort"
 	"time"
 
 	"github.com/gravitational/teleport"
@@ -121,6 +120,57 @@ type CreateSSHCertWithU2FReq struct {
 	Compatibility string `json:"compatibility,omitempty"`
 }
 
+// OIDCExchangeReq is passed by the web client to exchange an OIDC ID token
+// (obtained via a direct Authorization Code with PKCE flow, or a refresh
+// token) for a temporary cert signed by the auth server authority.
+type OIDCExchangeReq struct {
+	// IDToken is the OIDC id_token obtained from the connector's token
+	// endpoint. Empty when refreshing via RefreshToken.
+	IDToken string `json:"id_token,omitempty"`
+	// CodeVerifier is the PKCE code_verifier that must match the
+	// code_challenge used to obtain IDToken.
+	CodeVerifier string `json:"code_verifier,omitempty"`
+	// RefreshToken exchanges a previously issued OIDC refresh token for a
+	// fresh cert without requiring IDToken/CodeVerifier.
+	RefreshToken string `json:"refresh_token,omitempty"`
+	// PubKey is a public key user wishes to sign
+	PubKey []byte `json:"pub_key"`
+	// TTL is a desired TTL for the cert (max is still capped by server,
+	// however user can shorten the time)
+	TTL time.Duration `json:"ttl"`
+}
+
+// WebAuthnLoginBeginReq is passed by the web client to request a WebAuthn
+// assertion challenge. When Passwordless is set, User/Pass are ignored and
+// the server issues a resident-key/UV challenge instead.
+type WebAuthnLoginBeginReq struct {
+	// User is a teleport username
+	User string `json:"user,omitempty"`
+	// Pass is user's password
+	Pass string `json:"pass,omitempty"`
+	// Passwordless requests a resident-key/UV assertion that omits the
+	// password step entirely.
+	Passwordless bool `json:"passwordless,omitempty"`
+}
+
+// WebAuthnLoginFinishReq are passed by the web client to exchange a signed
+// WebAuthn assertion for a temporary cert signed by the auth server
+// authority.
+type WebAuthnLoginFinishReq struct {
+	// User is a teleport username. Empty for passwordless logins, where the
+	// user is identified by the assertion's resident credential.
+	User string `json:"user,omitempty"`
+	// AssertionResponse is the signed assertion from the security key.
+	AssertionResponse AuthenticatorAssertionResponse `json:"webauthn_assertion_response"`
+	// PubKey is a public key user wishes to sign
+	PubKey []byte `json:"pub_key"`
+	// TTL is a desired TTL for the cert (max is still capped by server,
+	// however user can shorten the time)
+	TTL time.Duration `json:"ttl"`
+	// Compatibility specifies OpenSSH compatibility flags.
+	Compatibility string `json:"compatibility,omitempty"`
+}
+
 // PingResponse contains data about the Teleport server like supported
 // authentication types, server version, etc.
 type PingResponse struct {
@@ -157,6 +207,47 @@ type SSHLogin struct {
 	// BindAddr is an optional host:port address to bind
 	// to for SSO login flows
 	BindAddr string
+	// OIDCIssuerURL is the base URL of the OIDC connector's issuer, used to
+	// perform a direct (non-SSO) Authorization Code with PKCE login that
+	// does not require the proxy to broker the browser callback.
+	OIDCIssuerURL string
+	// ClientID is the OAuth2 client ID registered with the OIDC issuer for
+	// direct login.
+	ClientID string
+	// Scopes is the list of OAuth2 scopes to request during direct OIDC
+	// login. Defaults to {"openid"} when empty.
+	Scopes []string
+	// RefreshToken is a previously issued OIDC refresh token. When set,
+	// SSHAgentOIDCLogin re-mints SSH certificates without opening a browser.
+	RefreshToken string
+	// ProxyURL overrides the HTTP(S)/SOCKS5 proxy used to reach the OIDC
+	// connector's token endpoint during SSHAgentOIDCLogin's direct login
+	// flow. When empty, http.ProxyFromEnvironment (HTTPS_PROXY/NO_PROXY)
+	// is used.
+	//
+	// SSHAgentSSOLogin's browser-callback Redirector is not wired through
+	// this (or TLSProfile/ProxyPins below): the Redirector lives outside
+	// this package and its constructor, NewRedirector, is not present in
+	// this source tree, so there is nothing here for a proxy dialer to
+	// attach to.
+	//
+	// TODO: this leaves the primary interactive `tsh login` path (browser
+	// SSO) unable to reach a proxy/MITM-CA'd cluster, while the less
+	// commonly used SSHAgentOIDCLogin and SSHAgentDeviceCodeLogin flows
+	// are proxy-aware. File a follow-up issue once the Redirector source
+	// is available here, and wire it the same way oidcTokenHTTPClient
+	// does for direct OIDC.
+	ProxyURL string
+	// TLSProfile selects the TLS version/cipher suite tier used for the
+	// connector's token endpoint during SSHAgentOIDCLogin. Defaults to
+	// TLSProfileDefault. Not used by SSHAgentSSOLogin; see the ProxyURL
+	// comment above.
+	TLSProfile TLSProfile
+	// ProxyPins is an optional list of "sha256//<base64>" SPKI pins that
+	// the connector's token endpoint certificate chain must match during
+	// SSHAgentOIDCLogin. Not used by SSHAgentSSOLogin; see the ProxyURL
+	// comment above.
+	ProxyPins []string
 }
 
 // ProxySettings contains basic information about proxy settings
@@ -199,12 +290,18 @@ type AuthenticationSettings struct {
 	// Type is the type of authentication, can be either local or oidc.
 	Type string `json:"type"`
 	// SecondFactor is the type of second factor to use in authentication.
-	// Supported options are: off, otp, and u2f.
+	// Supported options are: off, otp, u2f, and webauthn.
 	SecondFactor string `json:"second_factor,omitempty"`
 	// U2F contains the Universal Second Factor settings needed for authentication.
 	U2F *U2FSettings `json:"u2f,omitempty"`
+	// WebAuthn contains the WebAuthn/FIDO2 settings needed for authentication.
+	// When set, clients should prefer it over the legacy U2F protocol.
+	WebAuthn *WebAuthnSettings `json:"webauthn,omitempty"`
 	// OIDC contains OIDC connector settings needed for authentication.
 	OIDC *OIDCSettings `json:"oidc,omitempty"`
+	// Keycloak contains Keycloak direct grant / device-code settings needed
+	// for authentication, when the connector supports either flow.
+	Keycloak *KeycloakSettings `json:"keycloak,omitempty"`
 	// SAML contains SAML connector settings needed for authentication.
 	SAML *SAMLSettings `json:"saml,omitempty"`
 	// Github contains Github connector settings needed for authentication.
@@ -217,6 +314,105 @@ type U2FSettings struct {
 	AppID string `json:"app_id"`
 }
 
+// WebAuthnSettings contains the parameters needed to negotiate a WebAuthn
+// assertion with the auth server, letting the client advertise and use the
+// newer FIDO2/CTAP2 protocol while keeping legacy U2F as a fallback.
+type WebAuthnSettings struct {
+	// RPID is the WebAuthn Relying Party ID, usually the proxy's hostname.
+	RPID string `json:"rp_id"`
+	// UserVerification is the requested user verification policy, one of
+	// "required", "preferred", or "discouraged".
+	UserVerification string `json:"user_verification,omitempty"`
+	// Algorithms is the list of allowed COSE algorithm identifiers, in
+	// order of preference.
+	Algorithms []int `json:"algorithms,omitempty"`
+}
+
+// KeycloakSettings advertises a generic OIDC connector's support for the
+// Resource Owner Password Credentials ("direct grant") and RFC 8628 device
+// code flows, used where a full browser login is impossible (CI bots,
+// Kiosk mode).
+type KeycloakSettings struct {
+	// Name is the internal name of the connector.
+	Name string `json:"name"`
+	// Display is the display name for the connector.
+	Display string `json:"display"`
+	// TokenEndpoint is the connector issuer's OAuth2 token endpoint,
+	// advertised so client tooling can exchange credentials for tokens
+	// without having to separately fetch the issuer's discovery document.
+	TokenEndpoint string `json:"token_endpoint"`
+	// AllowDirectGrant is true when the connector permits exchanging a
+	// username/password/TOTP directly for tokens via
+	// SSHAgentDirectGrantLogin.
+	AllowDirectGrant bool `json:"allow_direct_grant,omitempty"`
+	// AllowDeviceCode is true when the connector supports the RFC 8628
+	// device authorization flow via SSHAgentDeviceCodeLogin.
+	AllowDeviceCode bool `json:"allow_device_code,omitempty"`
+	// ClaimsToRoles lists the connector's claim-to-role mappings (e.g.
+	// Keycloak/Azure AD "groups" to Teleport roles). The auth server's
+	// connector config is the authoritative copy and is what's actually
+	// evaluated when a cert is issued -- that evaluation lives in the auth
+	// package, outside lib/client. ClaimsToRoles is this copy, advertised
+	// so client tooling can preview the same decision (see
+	// MatchClaimsToRoles) before a user authenticates.
+	ClaimsToRoles []OIDCClaimMapping `json:"claims_to_roles,omitempty"`
+}
+
+// OIDCClaimMapping maps a single value of an ID token claim to the set of
+// Teleport roles a user asserting it should receive.
+type OIDCClaimMapping struct {
+	// Claim is the name of the claim inspected, e.g. "groups".
+	Claim string `json:"claim"`
+	// Value is the claim value that triggers this mapping.
+	Value string `json:"value"`
+	// Roles is the list of Teleport roles granted when Claim equals Value
+	// or, for an array claim, contains Value.
+	Roles []string `json:"roles"`
+}
+
+// MatchClaimsToRoles returns the sorted, deduplicated set of Teleport roles
+// that claims triggers under mappings. claims is the ID token's decoded
+// claim set, so a scalar claim like "department" or an array claim like
+// "groups" are both handled. This mirrors the evaluation the auth server
+// performs against its authoritative connector config when it mints a
+// cert; it is exposed so client tooling (e.g. "tsh login --debug") can
+// preview the same decision beforehand.
+func MatchClaimsToRoles(claims map[string]interface{}, mappings []OIDCClaimMapping) []string {
+	seen := make(map[string]bool)
+	var roles []string
+	for _, mapping := range mappings {
+		value, ok := claims[mapping.Claim]
+		if !ok || !claimValueMatches(value, mapping.Value) {
+			continue
+		}
+		for _, role := range mapping.Roles {
+			if !seen[role] {
+				seen[role] = true
+				roles = append(roles, role)
+			}
+		}
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// claimValueMatches reports whether claim v contains or equals want,
+// handling both a scalar claim value and an array claim value such as
+// "groups".
+func claimValueMatches(v interface{}, want string) bool {
+	switch t := v.(type) {
+	case string:
+		return t == want
+	case []interface{}:
+		for _, item := range t {
+			if s, ok := item.(string); ok && s == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // SAMLSettings contains the Name and Display string for SAML
 type SAMLSettings struct {
 	// Name is the internal name of the connector.
@@ -249,36 +445,95 @@ type CredentialsClient struct {
 	url *url.URL
 }
 
+// CredentialsClientConfig contains configuration for constructing a
+// CredentialsClient.
+type CredentialsClientConfig struct {
+	// ProxyAddr is the target proxy address.
+	ProxyAddr string
+	// Insecure turns off verification for x509 target proxy.
+	Insecure bool
+	// Pool is x509 cert pool to use for server certificate verification.
+	Pool *x509.CertPool
+	// ProxyCAPath is an optional path to a PEM-encoded CA bundle that is
+	// trusted in addition to Pool, letting users pin a corporate MITM
+	// gateway's CA without falling back to Insecure.
+	ProxyCAPath string
+	// HTTPProxy overrides the HTTP(S)/SOCKS5 proxy used to reach ProxyAddr.
+	// socks5:// URLs are dialed through golang.org/x/net/proxy. When empty,
+	// http.ProxyFromEnvironment (HTTPS_PROXY/NO_PROXY) is used.
+	HTTPProxy string
+	// TLSProfile selects the TLS version/cipher suite tier used to reach
+	// ProxyAddr. Defaults to TLSProfileSecure.
+	TLSProfile TLSProfile
+	// ProxyPins is an optional list of "sha256//<base64>" SPKI pins. When
+	// set, the handshake fails unless the proxy's certificate chain
+	// contains at least one matching public key, protecting against a
+	// compromised CA issuing a rogue proxy cert.
+	ProxyPins []string
+	// UnixSocket, when set, dials this Unix socket path instead of
+	// ProxyAddr over TCP, delegating transport security to the socket's
+	// file permissions. ProxyAddr is still used as the virtual host sent
+	// in the Host header, so the proxy's virtual-host routing keeps
+	// working.
+	UnixSocket string
+	// UpstreamScheme selects "http" or "https" when dialing over
+	// UnixSocket. Defaults to "http".
+	UpstreamScheme string
+}
+
 // NewCredentialsClient creates a new client to the HTTPS web proxy.
 func NewCredentialsClient(proxyAddr string, insecure bool, pool *x509.CertPool) (*CredentialsClient, error) {
+	return NewCredentialsClientWithConfig(CredentialsClientConfig{
+		ProxyAddr: proxyAddr,
+		Insecure:  insecure,
+		Pool:      pool,
+	})
+}
+
+// NewCredentialsClientWithConfig creates a new client to the HTTPS web
+// proxy, honouring proxy and extra CA settings in cfg.
+func NewCredentialsClientWithConfig(cfg CredentialsClientConfig) (*CredentialsClient, error) {
+	if cfg.UnixSocket != "" {
+		return newUnixSocketCredentialsClient(cfg)
+	}
+
 	log := logrus.WithFields(logrus.Fields{
 		trace.Component: teleport.ComponentClient,
 	})
-	log.Debugf("HTTPS client init(proxyAddr=%v, insecure=%v)", proxyAddr, insecure)
+	log.Debugf("HTTPS client init(proxyAddr=%v, insecure=%v)", cfg.ProxyAddr, cfg.Insecure)
 
 	// validate proxyAddr:
-	host, port, err := net.SplitHostPort(proxyAddr)
+	host, port, err := net.SplitHostPort(cfg.ProxyAddr)
 	if err != nil || host == "" || port == "" {
 		if err != nil {
 			log.Error(err)
 		}
-		return nil, trace.BadParameter("'%v' is not a valid proxy address", proxyAddr)
+		return nil, trace.BadParameter("'%v' is not a valid proxy address", cfg.ProxyAddr)
 	}
-	proxyAddr = "https://" + net.JoinHostPort(host, port)
+	proxyAddr := "https://" + net.JoinHostPort(host, port)
 	u, err := url.Parse(proxyAddr)
 	if err != nil {
 		return nil, trace.BadParameter("'%v' is not a valid proxy address", proxyAddr)
 	}
 
+	pool, err := mergeProxyCAPool(cfg.Pool, cfg.ProxyCAPath)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
 	var opts []roundtrip.ClientParam
 
-	if insecure {
+	if cfg.Insecure {
 		// Skip https cert verification, print a warning that this is insecure.
 		fmt.Printf("WARNING: You are using insecure connection to SSH proxy %v\n", proxyAddr)
-		opts = append(opts, roundtrip.HTTPClient(NewInsecureWebClient()))
-	} else if pool != nil {
-		// use custom set of trusted CAs
-		opts = append(opts, roundtrip.HTTPClient(newClientWithPool(pool)))
+	}
+
+	httpClient, err := buildWebHTTPClient(cfg, pool)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if httpClient != nil {
+		opts = append(opts, roundtrip.HTTPClient(httpClient))
 	}
 
 	clt, err := NewWebClient(proxyAddr, opts...)
@@ -396,6 +651,96 @@ func (c *CredentialsClient) SSHAgentSSOLogin(login SSHLogin) (*auth.SSHLoginResp
 	}
 }
 
+// SSHAgentOIDCLogin performs the OAuth2/OIDC Authorization Code flow with
+// PKCE directly against the connector's issuer, without relying on the
+// Teleport proxy to broker the browser redirect. It prints the authorization
+// URL and receives the code on a loopback listener (login.BindAddr, or an
+// ephemeral port on 127.0.0.1), so the browser that opens the URL must be
+// able to reach that listener. If login.RefreshToken is set, it is
+// exchanged instead of starting a fresh browser flow.
+func (c *CredentialsClient) SSHAgentOIDCLogin(login SSHLogin) (*auth.SSHLoginResponse, error) {
+	if login.RefreshToken != "" {
+		return c.RefreshSSHCert(login.Context, login.RefreshToken, login.PubKey, login.TTL)
+	}
+
+	pkce, err := newPKCEVerifier()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	lsn, callbackURL, err := newOIDCCallbackListener(login.BindAddr)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	defer lsn.Close()
+
+	state, err := newOIDCState()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	authURL, err := buildOIDCAuthURL(login, pkce, callbackURL, state)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	fmt.Printf("To log in, open this link in a browser:\n %v\n", authURL)
+
+	code, err := waitForOIDCCallback(login.Context, lsn, state)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	tokens, err := exchangeOIDCCode(login.Context, login, pkce, callbackURL, code)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	re, err := c.clt.PostJSON(login.Context, c.clt.Endpoint("webapi", "oidc", "exchange"), OIDCExchangeReq{
+		IDToken:      tokens.IDToken,
+		CodeVerifier: pkce.verifier,
+		PubKey:       login.PubKey,
+		TTL:          login.TTL,
+	})
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	var out *auth.SSHLoginResponse
+	if err := json.Unmarshal(re.Bytes(), &out); err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	if tokens.RefreshToken != "" {
+		if err := persistOIDCRefreshToken(login.ProxyAddr, tokens.RefreshToken); err != nil {
+			log.Debugf("Failed to persist OIDC refresh token: %v.", err)
+		}
+	}
+
+	return out, nil
+}
+
+// RefreshSSHCert silently re-mints SSH certificates using a previously
+// issued OIDC refresh token, without opening a browser. This mirrors the
+// refresh pattern used by long-running agents to keep an OIDC session alive.
+func (c *CredentialsClient) RefreshSSHCert(ctx context.Context, refreshToken string, pubKey []byte, ttl time.Duration) (*auth.SSHLoginResponse, error) {
+	re, err := c.clt.PostJSON(ctx, c.clt.Endpoint("webapi", "oidc", "exchange"), OIDCExchangeReq{
+		RefreshToken: refreshToken,
+		PubKey:       pubKey,
+		TTL:          ttl,
+	})
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	var out *auth.SSHLoginResponse
+	if err := json.Unmarshal(re.Bytes(), &out); err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	return out, nil
+}
+
 // SSHAgentLogin is used by tsh to fetch local user credentials.
 func (c *CredentialsClient) SSHAgentLogin(ctx context.Context, user string, password string, otpToken string, pubKey []byte, ttl time.Duration, compatibility string) (*auth.SSHLoginResponse, error) {
 	re, err := c.clt.PostJSON(ctx, c.clt.Endpoint("webapi", "ssh", "certs"), CreateSSHCertReq{
@@ -420,10 +765,10 @@ func (c *CredentialsClient) SSHAgentLogin(ctx context.Context, user string, pass
 }
 
 // SSHAgentU2FLogin requests a U2F sign request (authentication challenge) via
-// the proxy. If the credentials are valid, the proxy wiil return a challenge.
-// We then call the official u2f-host binary to perform the signing and pass
-// the signature to the proxy. If the authentication succeeds, we will get a
-// temporary certificate back.
+// the proxy. If the credentials are valid, the proxy will return a
+// challenge. We then talk to the security key directly over CTAP2/HID to
+// perform the signing and pass the signature to the proxy. If the
+// authentication succeeds, we will get a temporary certificate back.
 func (c *CredentialsClient) SSHAgentU2FLogin(ctx context.Context, user string, password string, pubKey []byte, ttl time.Duration, compatibility string) (*auth.SSHLoginResponse, error) {
 	u2fSignRequest, err := c.clt.PostJSON(ctx, c.clt.Endpoint("webapi", "u2f", "signrequest"), U2fSignRequestReq{
 		User: user,
@@ -433,71 +778,91 @@ func (c *CredentialsClient) SSHAgentU2FLogin(ctx context.Context, user string, p
 		return nil, trace.Wrap(err)
 	}
 
-	// Pass the JSON-encoded data undecoded to the u2f-host binary
-	facet := "https://" + strings.ToLower(c.url.String())
-	cmd := exec.Command("u2f-host", "-aauthenticate", "-o", facet)
-	stdin, err := cmd.StdinPipe()
+	var challenge *u2f.SignRequest
+	if err := json.Unmarshal(u2fSignRequest.Bytes(), &challenge); err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	fmt.Println("Please press the button on your U2F key")
+	u2fSignResponse, err := signU2FChallenge(ctx, challenge)
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
-	stdout, err := cmd.StdoutPipe()
+
+	re, err := c.clt.PostJSON(ctx, c.clt.Endpoint("webapi", "u2f", "certs"), CreateSSHCertWithU2FReq{
+		User:            user,
+		U2FSignResponse: *u2fSignResponse,
+		PubKey:          pubKey,
+		TTL:             ttl,
+		Compatibility:   compatibility,
+	})
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
-	stderr, err := cmd.StderrPipe()
+
+	var out *auth.SSHLoginResponse
+	err = json.Unmarshal(re.Bytes(), &out)
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
 
-	cmd.Start()
-	stdin.Write(u2fSignRequest.Bytes())
-	stdin.Close()
-	fmt.Println("Please press the button on your U2F key")
+	return out, nil
+}
 
-	// The origin URL is passed back base64-encoded and the keyHandle is passed back as is.
-	// A very long proxy hostname or keyHandle can overflow a fixed-size buffer.
-	signResponseLen := 500 + len(u2fSignRequest.Bytes()) + len(c.url.String())*4/3
-	signResponseBuf := make([]byte, signResponseLen)
-	signResponseLen, err = io.ReadFull(stdout, signResponseBuf)
-	// unexpected EOF means we have read the data completely.
-	if err == nil {
-		return nil, trace.LimitExceeded("u2f sign response exceeded buffer size")
+// SSHAgentWebAuthnLogin requests a WebAuthn assertion challenge via the
+// proxy, signs it with an in-process CTAP2 authenticator, and exchanges the
+// resulting assertion for a temporary certificate. It supersedes
+// SSHAgentU2FLogin for connectors that advertise WebAuthn support.
+func (c *CredentialsClient) SSHAgentWebAuthnLogin(ctx context.Context, user string, password string, pubKey []byte, ttl time.Duration, compatibility string) (*auth.SSHLoginResponse, error) {
+	return c.webAuthnLogin(ctx, WebAuthnLoginBeginReq{
+		User: user,
+		Pass: password,
+	}, pubKey, ttl, compatibility)
+}
+
+// PasswordlessLogin performs a resident-key/UV WebAuthn assertion without a
+// password step, letting the security key itself identify the user.
+func (c *CredentialsClient) PasswordlessLogin(ctx context.Context, pubKey []byte, ttl time.Duration, compatibility string) (*auth.SSHLoginResponse, error) {
+	return c.webAuthnLogin(ctx, WebAuthnLoginBeginReq{
+		Passwordless: true,
+	}, pubKey, ttl, compatibility)
+}
+
+func (c *CredentialsClient) webAuthnLogin(ctx context.Context, beginReq WebAuthnLoginBeginReq, pubKey []byte, ttl time.Duration, compatibility string) (*auth.SSHLoginResponse, error) {
+	beginResp, err := c.clt.PostJSON(ctx, c.clt.Endpoint("webapi", "webauthn", "login", "begin"), beginReq)
+	if err != nil {
+		return nil, trace.Wrap(err)
 	}
 
-	// Read error message (if any). 100 bytes is more than enough for any error message u2f-host outputs
-	errMsgBuf := make([]byte, 100)
-	errMsgLen, err := io.ReadFull(stderr, errMsgBuf)
-	if err == nil {
-		return nil, trace.LimitExceeded("u2f error message exceeded buffer size")
+	var assertion *CredentialAssertion
+	if err := json.Unmarshal(beginResp.Bytes(), &assertion); err != nil {
+		return nil, trace.Wrap(err)
 	}
 
-	err = cmd.Wait()
-	if err != nil {
-		return nil, trace.AccessDenied("u2f-host returned error: " + string(errMsgBuf[:errMsgLen]))
-	} else if signResponseLen == 0 {
-		return nil, trace.NotFound("u2f-host returned no error and no sign response")
+	if !beginReq.Passwordless {
+		fmt.Println("Please insert your security key and press the button")
+	} else {
+		fmt.Println("Please insert a registered security key")
 	}
 
-	var u2fSignResponse *u2f.SignResponse
-	err = json.Unmarshal(signResponseBuf[:signResponseLen], &u2fSignResponse)
+	assertionResponse, err := signWebAuthnAssertion(ctx, assertion)
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
 
-	re, err := c.clt.PostJSON(ctx, c.clt.Endpoint("webapi", "u2f", "certs"), CreateSSHCertWithU2FReq{
-		User:            user,
-		U2FSignResponse: *u2fSignResponse,
-		PubKey:          pubKey,
-		TTL:             ttl,
-		Compatibility:   compatibility,
+	re, err := c.clt.PostJSON(ctx, c.clt.Endpoint("webapi", "webauthn", "login", "finish"), WebAuthnLoginFinishReq{
+		User:              beginReq.User,
+		AssertionResponse: *assertionResponse,
+		PubKey:            pubKey,
+		TTL:               ttl,
+		Compatibility:     compatibility,
 	})
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
 
 	var out *auth.SSHLoginResponse
-	err = json.Unmarshal(re.Bytes(), &out)
-	if err != nil {
+	if err := json.Unmarshal(re.Bytes(), &out); err != nil {
 		return nil, trace.Wrap(err)
 	}
 
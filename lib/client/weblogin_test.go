@@ -0,0 +1,71 @@
+/*
+Copyright 2015-2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatchClaimsToRoles(t *testing.T) {
+	mappings := []OIDCClaimMapping{
+		{Claim: "groups", Value: "admins", Roles: []string{"access", "admin"}},
+		{Claim: "groups", Value: "devs", Roles: []string{"access"}},
+		{Claim: "department", Value: "sre", Roles: []string{"access", "sre"}},
+	}
+
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		want   []string
+	}{
+		{
+			name:   "array claim matches",
+			claims: map[string]interface{}{"groups": []interface{}{"devs", "other"}},
+			want:   []string{"access"},
+		},
+		{
+			name:   "multiple mappings match and dedupe",
+			claims: map[string]interface{}{"groups": []interface{}{"admins", "devs"}},
+			want:   []string{"access", "admin"},
+		},
+		{
+			name:   "scalar claim matches",
+			claims: map[string]interface{}{"department": "sre"},
+			want:   []string{"access", "sre"},
+		},
+		{
+			name:   "no matching claim",
+			claims: map[string]interface{}{"groups": []interface{}{"nobody"}},
+			want:   nil,
+		},
+		{
+			name:   "missing claim",
+			claims: map[string]interface{}{},
+			want:   nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := MatchClaimsToRoles(tt.claims, mappings)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MatchClaimsToRoles() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}